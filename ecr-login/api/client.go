@@ -18,12 +18,14 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecrpublic"
 	"github.com/awslabs/amazon-ecr-credential-helper/ecr-login/cache"
 	"github.com/sirupsen/logrus"
 )
@@ -31,13 +33,22 @@ import (
 const proxyEndpointScheme = "https://"
 const programName = "docker-credential-ecr-login"
 
+// ecrPublicRegistryID and ecrPublicRegion identify ECR Public, which -
+// unlike private ECR - is a single registry always reached via us-east-1.
+const ecrPublicRegistryID = "public.ecr.aws"
+const ecrPublicRegion = "us-east-1"
+
 var ecrPattern = regexp.MustCompile(`(^[a-zA-Z0-9][a-zA-Z0-9-_]*)\.dkr\.ecr(\-fips)?\.([a-zA-Z0-9][a-zA-Z0-9-_]*)\.amazonaws\.com(\.cn)?`)
+var ecrPublicPattern = regexp.MustCompile(`^public\.ecr\.aws(/|$)`)
 
 // Registry in ECR
 type Registry struct {
 	ID     string
 	FIPS   bool
 	Region string
+	// Public is true for registries served by ECR Public (public.ecr.aws)
+	// rather than private ECR.
+	Public bool
 }
 
 // ExtractRegistry returns the ECR registry behind a given service endpoint
@@ -59,20 +70,72 @@ func ExtractRegistry(serverURL string) (*Registry, error) {
 	return registry, nil
 }
 
+// ExtractPublicRegistry returns the ECR Public registry behind a given
+// service endpoint, e.g. public.ecr.aws/<alias>/<repo>. ECR Public has no
+// per-account registry ID or region in its URL, so the returned Registry
+// always has the same ID and is pinned to us-east-1.
+func ExtractPublicRegistry(serverURL string) (*Registry, error) {
+	if strings.HasPrefix(serverURL, proxyEndpointScheme) {
+		serverURL = strings.TrimPrefix(serverURL, proxyEndpointScheme)
+	}
+	if !ecrPublicPattern.MatchString(serverURL) {
+		return nil, fmt.Errorf(serverURL + " is not a valid repository URI for Amazon ECR Public.")
+	}
+	return &Registry{
+		ID:     ecrPublicRegistryID,
+		Region: ecrPublicRegion,
+		Public: true,
+	}, nil
+}
+
 // Client used for calling ECR service
 type Client interface {
 	GetCredentials(serverURL string) (*Auth, error)
-	GetCredentialsByRegistryID(registryID string) (*Auth, error)
+	// GetCredentialsByRegistryID looks up credentials for a registry ID. The
+	// region is optional: if omitted, it is taken from the last Registry
+	// observed for that registry ID (via GetCredentials, a call with an
+	// explicit region, or the ProxyEndpoint of a fetched token).
+	GetCredentialsByRegistryID(registryID string, region ...string) (*Auth, error)
 	ListCredentials() ([]*Auth, error)
 }
 type defaultClient struct {
-	ecrClient       ECRAPI
+	clientFactory   ClientFactory
 	credentialCache cache.CredentialsCache
+	defaultRegion   string
+
+	ecrClientsLock sync.Mutex
+	ecrClients     map[string]ECRAPI
+	ecrPublic      ECRPublicAPI
+
+	registriesLock sync.Mutex
+	registries     map[string]*Registry
+}
+
+// NewClient returns a Client that lazily builds one ECRAPI per (registry ID,
+// region, FIPS) combination it is asked to serve, rather than being bound to a single
+// region for its lifetime. defaultRegion is used when a caller asks for
+// credentials without identifying a specific registry (e.g. ListCredentials
+// on an empty cache).
+func NewClient(clientFactory ClientFactory, credentialCache cache.CredentialsCache, defaultRegion string) Client {
+	return &defaultClient{
+		clientFactory:   clientFactory,
+		credentialCache: credentialCache,
+		defaultRegion:   defaultRegion,
+		ecrClients:      make(map[string]ECRAPI),
+		registries:      make(map[string]*Registry),
+	}
 }
+
 type ECRAPI interface {
 	GetAuthorizationToken(*ecr.GetAuthorizationTokenInput) (*ecr.GetAuthorizationTokenOutput, error)
 }
 
+// ECRPublicAPI is the subset of the ecr-public service used to fetch
+// authorization tokens for ECR Public (public.ecr.aws).
+type ECRPublicAPI interface {
+	GetAuthorizationToken(*ecrpublic.GetAuthorizationTokenInput) (*ecrpublic.GetAuthorizationTokenOutput, error)
+}
+
 // Auth credentials returned by ECR service to allow docker login
 type Auth struct {
 	ProxyEndpoint string
@@ -82,6 +145,11 @@ type Auth struct {
 
 // GetCredentials returns username, password, and proxyEndpoint
 func (c *defaultClient) GetCredentials(serverURL string) (*Auth, error) {
+	if _, err := ExtractPublicRegistry(serverURL); err == nil {
+		logrus.WithField("serverURL", serverURL).Debug("Retrieving ECR Public credentials")
+		return c.GetCredentialsByRegistryID(ecrPublicRegistryID)
+	}
+
 	registry, err := ExtractRegistry(serverURL)
 	if err != nil {
 		return nil, err
@@ -91,15 +159,26 @@ func (c *defaultClient) GetCredentials(serverURL string) (*Auth, error) {
 		WithField("region", registry.Region).
 		WithField("serverURL", serverURL).
 		Debug("Retrieving credentials")
-	return c.GetCredentialsByRegistryID(registry.ID)
+	c.rememberRegistry(registry)
+	return c.GetCredentialsByRegistryID(registry.ID, registry.Region)
 }
 
 // GetCredentials returns username, password, and proxyEndpoint
-func (c *defaultClient) GetCredentialsByRegistryID(registryID string) (*Auth, error) {
-	cachedEntry := c.credentialCache.Get(registryID)
+func (c *defaultClient) GetCredentialsByRegistryID(registryID string, region ...string) (*Auth, error) {
+	if registryID == ecrPublicRegistryID {
+		return c.getPublicAuthorizationToken()
+	}
+
+	registry, err := c.resolveRegistry(registryID, region...)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := cacheKey(registry)
+	cachedEntry := c.credentialCache.Get(cacheKey)
 	if cachedEntry != nil {
 		if cachedEntry.IsValid(time.Now()) {
-			logrus.WithField("registry", registryID).Debug("Using cached token")
+			logrus.WithField("registry", registryID).WithField("region", registry.Region).Debug("Using cached token")
 			return extractToken(cachedEntry.AuthorizationToken, cachedEntry.ProxyEndpoint)
 		}
 		logrus.
@@ -108,7 +187,7 @@ func (c *defaultClient) GetCredentialsByRegistryID(registryID string) (*Auth, er
 			Debug("Cached token is no longer valid")
 	}
 
-	auth, err := c.getAuthorizationToken(registryID)
+	auth, err := c.getAuthorizationToken(registry)
 
 	// if we have a cached token, fall back to avoid failing the request. This may result an expired token
 	// being returned, but if there is a 500 or timeout from the service side, we'd like to attempt to re-use an
@@ -120,6 +199,56 @@ func (c *defaultClient) GetCredentialsByRegistryID(registryID string) (*Auth, er
 	return auth, err
 }
 
+// resolveRegistry fills in the region/FIPS flag for registryID, preferring an
+// explicitly passed region and otherwise falling back to the last Registry
+// remembered for that ID.
+func (c *defaultClient) resolveRegistry(registryID string, region ...string) (*Registry, error) {
+	if len(region) > 0 && region[0] != "" {
+		registry := &Registry{ID: registryID, Region: region[0]}
+
+		// Preserve the FIPS flag from a previously observed Registry for the
+		// same (ID, region) rather than silently dropping it, since the caller
+		// here only supplies ID and region.
+		c.registriesLock.Lock()
+		if existing, ok := c.registries[registryID]; ok && existing.Region == registry.Region {
+			registry.FIPS = existing.FIPS
+		}
+		c.registriesLock.Unlock()
+
+		c.rememberRegistry(registry)
+		return registry, nil
+	}
+
+	c.registriesLock.Lock()
+	defer c.registriesLock.Unlock()
+	if registry, ok := c.registries[registryID]; ok {
+		return registry, nil
+	}
+	return nil, fmt.Errorf("no region known for registry %s; call GetCredentials with a server URL first or pass a region explicitly", registryID)
+}
+
+func (c *defaultClient) rememberRegistry(registry *Registry) {
+	c.registriesLock.Lock()
+	defer c.registriesLock.Unlock()
+	c.registries[registry.ID] = registry
+}
+
+// cacheKey returns the credential cache key for a registry, namespaced by
+// region and FIPS-ness so that the same account in different regions (or
+// with and without a FIPS endpoint) doesn't collide in the cache. Public
+// ECR entries are namespaced separately so they can never collide with a
+// private registry that happens to share an ID.
+func cacheKey(registry *Registry) string {
+	key := registry.ID + "." + registry.Region
+	if registry.FIPS {
+		key += ".fips"
+	}
+	if registry.Public {
+		key += ".public"
+	}
+	return key
+}
+
 func (c *defaultClient) ListCredentials() ([]*Auth, error) {
 	auths := []*Auth{}
 	for _, authEntry := range c.credentialCache.List() {
@@ -134,7 +263,7 @@ func (c *defaultClient) ListCredentials() ([]*Auth, error) {
 	// If cache is empty, get authorization token of default registry
 	if len(auths) == 0 {
 		logrus.Debug("No credential cache")
-		auth, err := c.getAuthorizationToken("")
+		auth, err := c.getAuthorizationToken(&Registry{Region: c.defaultRegion})
 		if err != nil {
 			logrus.WithError(err).Debugf("Couldn't get authorization token")
 		} else {
@@ -146,25 +275,62 @@ func (c *defaultClient) ListCredentials() ([]*Auth, error) {
 	return auths, nil
 }
 
-func (c *defaultClient) getAuthorizationToken(registryID string) (*Auth, error) {
+// ecrClientFor returns the ECRAPI bound to registry.Region (and its FIPS
+// setting), constructing and caching one via the ClientFactory if this is
+// the first time that registry ID, region and FIPS setting have been seen
+// together. ClientFactory.NewClientFromRegion
+// is called without holding ecrClientsLock, since credential-source factories
+// may make network calls (e.g. to assume a role) to build the client; only
+// the map access itself is synchronized, so an unrelated registry is never
+// blocked behind another registry's setup.
+func (c *defaultClient) ecrClientFor(registry *Registry) (ECRAPI, error) {
+	key := cacheKey(registry)
+
+	c.ecrClientsLock.Lock()
+	ecrClient, ok := c.ecrClients[key]
+	c.ecrClientsLock.Unlock()
+	if ok {
+		return ecrClient, nil
+	}
+
+	ecrClient, err := c.clientFactory.NewClientFromRegion(registry.ID, registry.Region, registry.FIPS)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ecrClientsLock.Lock()
+	defer c.ecrClientsLock.Unlock()
+	if existing, ok := c.ecrClients[key]; ok {
+		return existing, nil
+	}
+	c.ecrClients[key] = ecrClient
+	return ecrClient, nil
+}
+
+func (c *defaultClient) getAuthorizationToken(registry *Registry) (*Auth, error) {
+	ecrClient, err := c.ecrClientFor(registry)
+	if err != nil {
+		return nil, err
+	}
+
 	var input *ecr.GetAuthorizationTokenInput
-	if registryID == "" {
-		logrus.Debug("Calling ECR.GetAuthorizationToken for default registry")
+	if registry.ID == "" {
+		logrus.WithField("region", registry.Region).Debug("Calling ECR.GetAuthorizationToken for default registry")
 		input = &ecr.GetAuthorizationTokenInput{}
 	} else {
-		logrus.WithField("registry", registryID).Debug("Calling ECR.GetAuthorizationToken")
+		logrus.WithField("registry", registry.ID).WithField("region", registry.Region).Debug("Calling ECR.GetAuthorizationToken")
 		input = &ecr.GetAuthorizationTokenInput{
-			RegistryIds: []*string{aws.String(registryID)},
+			RegistryIds: []*string{aws.String(registry.ID)},
 		}
 	}
 
-	output, err := c.ecrClient.GetAuthorizationToken(input)
+	output, err := ecrClient.GetAuthorizationToken(input)
 	if err != nil || output == nil {
 		if err == nil {
-			if registryID == "" {
+			if registry.ID == "" {
 				err = fmt.Errorf("missing AuthorizationData in ECR response for default registry")
 			} else {
-				err = fmt.Errorf("missing AuthorizationData in ECR response for %s", registryID)
+				err = fmt.Errorf("missing AuthorizationData in ECR response for %s", registry.ID)
 			}
 		}
 		return nil, errors.Wrap(err, "ecr: Failed to get authorization token")
@@ -178,7 +344,7 @@ func (c *defaultClient) getAuthorizationToken(registryID string) (*Auth, error)
 				ExpiresAt:          aws.TimeValue(authData.ExpiresAt),
 				ProxyEndpoint:      aws.StringValue(authData.ProxyEndpoint),
 			}
-			registry, err := ExtractRegistry(authEntry.ProxyEndpoint)
+			resolvedRegistry, err := ExtractRegistry(authEntry.ProxyEndpoint)
 			if err != nil {
 				return nil, fmt.Errorf("Invalid ProxyEndpoint returned by ECR: %s", authEntry.ProxyEndpoint)
 			}
@@ -186,14 +352,94 @@ func (c *defaultClient) getAuthorizationToken(registryID string) (*Auth, error)
 			if err != nil {
 				return nil, err
 			}
-			c.credentialCache.Set(registry.ID, &authEntry)
+			c.rememberRegistry(resolvedRegistry)
+			c.credentialCache.Set(cacheKey(resolvedRegistry), &authEntry)
 			return auth, nil
 		}
 	}
-	if registryID == "" {
+	if registry.ID == "" {
 		return nil, fmt.Errorf("No AuthorizationToken found for default registry")
 	}
-	return nil, fmt.Errorf("No AuthorizationToken found for %s", registryID)
+	return nil, fmt.Errorf("No AuthorizationToken found for %s", registry.ID)
+}
+
+var publicRegistry = &Registry{ID: ecrPublicRegistryID, Region: ecrPublicRegion, Public: true}
+
+// ecrPublicClient returns the ECRPublicAPI client, constructing and caching
+// it via the ClientFactory on first use. ECR Public has no per-registry or
+// per-region dimension, so a single client is cached for the lifetime of
+// the defaultClient. As with ecrClientFor, ClientFactory.NewPublicClient is
+// called without holding ecrClientsLock, since it may make network calls
+// (e.g. to assume a role), and ecrClientsLock is shared with private-registry
+// lookups.
+func (c *defaultClient) ecrPublicClient() (ECRPublicAPI, error) {
+	c.ecrClientsLock.Lock()
+	ecrPublicClient := c.ecrPublic
+	c.ecrClientsLock.Unlock()
+	if ecrPublicClient != nil {
+		return ecrPublicClient, nil
+	}
+
+	ecrPublicClient, err := c.clientFactory.NewPublicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c.ecrClientsLock.Lock()
+	defer c.ecrClientsLock.Unlock()
+	if c.ecrPublic != nil {
+		return c.ecrPublic, nil
+	}
+	c.ecrPublic = ecrPublicClient
+	return c.ecrPublic, nil
+}
+
+func (c *defaultClient) getPublicAuthorizationToken() (*Auth, error) {
+	key := cacheKey(publicRegistry)
+	cachedEntry := c.credentialCache.Get(key)
+	if cachedEntry != nil {
+		if cachedEntry.IsValid(time.Now()) {
+			logrus.Debug("Using cached ECR Public token")
+			return extractToken(cachedEntry.AuthorizationToken, cachedEntry.ProxyEndpoint)
+		}
+		logrus.Debug("Cached ECR Public token is no longer valid")
+	}
+
+	auth, err := c.fetchPublicAuthorizationToken()
+	if err != nil && cachedEntry != nil {
+		logrus.WithError(err).Info("Got error fetching ECR Public authorization token. Falling back to cached token.")
+		return extractToken(cachedEntry.AuthorizationToken, cachedEntry.ProxyEndpoint)
+	}
+	return auth, err
+}
+
+func (c *defaultClient) fetchPublicAuthorizationToken() (*Auth, error) {
+	ecrPublicClient, err := c.ecrPublicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Debug("Calling ECRPublic.GetAuthorizationToken")
+	output, err := ecrPublicClient.GetAuthorizationToken(&ecrpublic.GetAuthorizationTokenInput{})
+	if err != nil {
+		return nil, errors.Wrap(err, "ecr-public: Failed to get authorization token")
+	}
+	if output == nil || output.AuthorizationData == nil || output.AuthorizationData.AuthorizationToken == nil || output.AuthorizationData.ExpiresAt == nil {
+		return nil, fmt.Errorf("missing AuthorizationData in ECR Public response")
+	}
+
+	authEntry := cache.AuthEntry{
+		AuthorizationToken: aws.StringValue(output.AuthorizationData.AuthorizationToken),
+		RequestedAt:        time.Now(),
+		ExpiresAt:          aws.TimeValue(output.AuthorizationData.ExpiresAt),
+		ProxyEndpoint:      proxyEndpointScheme + ecrPublicRegistryID,
+	}
+	auth, err := extractToken(authEntry.AuthorizationToken, authEntry.ProxyEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	c.credentialCache.Set(cacheKey(publicRegistry), &authEntry)
+	return auth, nil
 }
 
 func extractToken(token string, proxyEndpoint string) (*Auth, error) {
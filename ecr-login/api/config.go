@@ -0,0 +1,87 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package api
+
+import (
+	"io/ioutil"
+	"os"
+
+	homedir "github.com/mitchellh/go-homedir"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultCredentialSourceConfigPath is read by LoadCredentialSourceConfig
+// when no path is given.
+const DefaultCredentialSourceConfigPath = "~/.ecr/config.yaml"
+
+// CredentialSourceConfig maps registry IDs to the AWS credentials that
+// should be used to call ECR on their behalf, for multi-account setups
+// where a single host needs different IAM principals per registry.
+type CredentialSourceConfig struct {
+	Registries map[string]RegistryCredentialSource `yaml:"registries"`
+}
+
+// RegistryCredentialSource is the credential source configured for one
+// registry ID. Exactly one of Profile or AssumeRole is expected to be set;
+// if neither is, the default credential chain is used.
+type RegistryCredentialSource struct {
+	Profile    string            `yaml:"profile,omitempty"`
+	AssumeRole *AssumeRoleConfig `yaml:"assumeRole,omitempty"`
+}
+
+// AssumeRoleConfig describes a role to assume, either directly via
+// AssumeRole or, when WebIdentityTokenFile is set, via
+// AssumeRoleWithWebIdentity (e.g. for IRSA/OIDC).
+type AssumeRoleConfig struct {
+	RoleARN              string `yaml:"roleARN"`
+	ExternalID           string `yaml:"externalID,omitempty"`
+	SessionName          string `yaml:"sessionName,omitempty"`
+	WebIdentityTokenFile string `yaml:"webIdentityTokenFile,omitempty"`
+}
+
+// LoadCredentialSourceConfig reads a CredentialSourceConfig from path,
+// defaulting to DefaultCredentialSourceConfigPath. A missing file is not
+// an error: it is treated as an empty config, so every registry falls
+// back to the default credential chain.
+func LoadCredentialSourceConfig(path string) (*CredentialSourceConfig, error) {
+	if path == "" {
+		path = DefaultCredentialSourceConfigPath
+	}
+	expanded, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(expanded)
+	if os.IsNotExist(err) {
+		return &CredentialSourceConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	config := &CredentialSourceConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// sourceFor returns the configured credential source for registryID, if any.
+func (c *CredentialSourceConfig) sourceFor(registryID string) (RegistryCredentialSource, bool) {
+	if c == nil {
+		return RegistryCredentialSource{}, false
+	}
+	source, ok := c.Registries[registryID]
+	return source, ok
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCredentialSourceConfigMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecr-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config, err := LoadCredentialSourceConfig(filepath.Join(dir, "does-not-exist.yaml"))
+	if err != nil {
+		t.Fatalf("expected no error for a missing config file, got: %v", err)
+	}
+	if len(config.Registries) != 0 {
+		t.Fatalf("expected an empty config, got: %+v", config)
+	}
+}
+
+func TestLoadCredentialSourceConfigMalformedYAML(t *testing.T) {
+	path := writeConfigFile(t, "registries: [this is not a map]")
+
+	if _, err := LoadCredentialSourceConfig(path); err == nil {
+		t.Fatal("expected an error for malformed YAML, got none")
+	}
+}
+
+func TestLoadCredentialSourceConfigParsesProfileAndAssumeRole(t *testing.T) {
+	path := writeConfigFile(t, `
+registries:
+  111111111111:
+    profile: some-profile
+  222222222222:
+    assumeRole:
+      roleARN: arn:aws:iam::222222222222:role/ecr-reader
+      externalID: some-external-id
+      sessionName: ecr-login
+  333333333333:
+    assumeRole:
+      roleARN: arn:aws:iam::333333333333:role/ecr-irsa
+      webIdentityTokenFile: /var/run/secrets/token
+`)
+
+	config, err := LoadCredentialSourceConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %v", err)
+	}
+
+	profileSource, ok := config.sourceFor("111111111111")
+	if !ok || profileSource.Profile != "some-profile" {
+		t.Fatalf("expected profile source for 111111111111, got: %+v, %v", profileSource, ok)
+	}
+
+	assumeRoleSource, ok := config.sourceFor("222222222222")
+	if !ok || assumeRoleSource.AssumeRole == nil {
+		t.Fatalf("expected assumeRole source for 222222222222, got: %+v, %v", assumeRoleSource, ok)
+	}
+	if assumeRoleSource.AssumeRole.RoleARN != "arn:aws:iam::222222222222:role/ecr-reader" {
+		t.Errorf("unexpected roleARN: %s", assumeRoleSource.AssumeRole.RoleARN)
+	}
+	if assumeRoleSource.AssumeRole.ExternalID != "some-external-id" {
+		t.Errorf("unexpected externalID: %s", assumeRoleSource.AssumeRole.ExternalID)
+	}
+
+	webIdentitySource, ok := config.sourceFor("333333333333")
+	if !ok || webIdentitySource.AssumeRole == nil || webIdentitySource.AssumeRole.WebIdentityTokenFile != "/var/run/secrets/token" {
+		t.Fatalf("expected web-identity assumeRole source for 333333333333, got: %+v, %v", webIdentitySource, ok)
+	}
+}
+
+func TestCredentialSourceConfigSourceForMissingRegistry(t *testing.T) {
+	config := &CredentialSourceConfig{Registries: map[string]RegistryCredentialSource{
+		"111111111111": {Profile: "some-profile"},
+	}}
+
+	if _, ok := config.sourceFor("222222222222"); ok {
+		t.Fatal("expected no source for a registry not present in the config")
+	}
+}
+
+func TestCredentialSourceConfigSourceForNilConfig(t *testing.T) {
+	var config *CredentialSourceConfig
+
+	if _, ok := config.sourceFor("111111111111"); ok {
+		t.Fatal("expected no source when the config itself is nil")
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "ecr-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
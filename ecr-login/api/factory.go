@@ -0,0 +1,171 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package api
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecrpublic"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ClientFactory builds the ECRAPI client used to reach a particular registry.
+// It is consulted once per (registry ID, region, fips) combination the helper
+// observes and the result is cached by the caller.
+type ClientFactory interface {
+	NewClientFromRegion(registryID string, region string, fipsEndpoint bool) (ECRAPI, error)
+	// NewPublicClient builds the ECRPublicAPI client used to reach ECR
+	// Public, which is always in us-east-1 regardless of the caller's region.
+	NewPublicClient() (ECRPublicAPI, error)
+}
+
+type defaultClientFactory struct {
+	session *session.Session
+}
+
+// NewClientFactory returns the default ClientFactory, which builds an ECR
+// client from the given session using whatever credential chain the session
+// was configured with.
+func NewClientFactory(sess *session.Session) ClientFactory {
+	return &defaultClientFactory{session: sess}
+}
+
+func (f *defaultClientFactory) NewClientFromRegion(registryID string, region string, fipsEndpoint bool) (ECRAPI, error) {
+	config := aws.NewConfig().WithRegion(region)
+	if fipsEndpoint {
+		endpoint, err := fipsEndpointForRegion(region)
+		if err != nil {
+			return nil, err
+		}
+		config = config.WithEndpoint(endpoint)
+	}
+	return ecr.New(f.session, config), nil
+}
+
+func (f *defaultClientFactory) NewPublicClient() (ECRPublicAPI, error) {
+	return ecrpublic.New(f.session, aws.NewConfig().WithRegion(ecrPublicRegion)), nil
+}
+
+func fipsEndpointForRegion(region string) (string, error) {
+	return "https://ecr-fips." + region + ".amazonaws.com", nil
+}
+
+// credentialSourceClientFactory builds ECR clients using credentials looked
+// up per-registry in a CredentialSourceConfig, falling back to the default
+// session's credential chain when a registry has no override. This supports
+// multi-account setups where a single host needs to assume different IAM
+// roles depending on which account's ECR it is calling.
+type credentialSourceClientFactory struct {
+	session *session.Session
+	config  *CredentialSourceConfig
+}
+
+// NewClientFactoryWithCredentialSources returns a ClientFactory that
+// consults config to decide which credentials to use for a given registry
+// ID, rather than always using sess's credential chain.
+func NewClientFactoryWithCredentialSources(sess *session.Session, config *CredentialSourceConfig) ClientFactory {
+	return &credentialSourceClientFactory{session: sess, config: config}
+}
+
+func (f *credentialSourceClientFactory) NewClientFromRegion(registryID string, region string, fipsEndpoint bool) (ECRAPI, error) {
+	sess := f.session
+	if source, ok := f.config.sourceFor(registryID); ok {
+		resolvedSession, principal, err := f.sessionForSource(region, source)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ecr: failed to resolve credential source for registry %s", registryID)
+		}
+		logrus.
+			WithField("registry", registryID).
+			WithField("principal", principal).
+			Debug("Using configured credential source")
+		sess = resolvedSession
+	}
+
+	config := aws.NewConfig().WithRegion(region)
+	if fipsEndpoint {
+		endpoint, err := fipsEndpointForRegion(region)
+		if err != nil {
+			return nil, err
+		}
+		config = config.WithEndpoint(endpoint)
+	}
+	return ecr.New(sess, config), nil
+}
+
+func (f *credentialSourceClientFactory) NewPublicClient() (ECRPublicAPI, error) {
+	sess := f.session
+	if source, ok := f.config.sourceFor(ecrPublicRegistryID); ok {
+		resolvedSession, principal, err := f.sessionForSource(ecrPublicRegion, source)
+		if err != nil {
+			return nil, errors.Wrap(err, "ecr-public: failed to resolve credential source")
+		}
+		logrus.WithField("principal", principal).Debug("Using configured credential source for ECR Public")
+		sess = resolvedSession
+	}
+	return ecrpublic.New(sess, aws.NewConfig().WithRegion(ecrPublicRegion)), nil
+}
+
+// sessionForSource returns the AWS session to use for source, along with a
+// human-readable principal for logging. STS-derived credentials (from
+// AssumeRole/AssumeRoleWithWebIdentity) are cached and refreshed by the
+// returned session's *credentials.Credentials until they are near expiry.
+func (f *credentialSourceClientFactory) sessionForSource(region string, source RegistryCredentialSource) (*session.Session, string, error) {
+	switch {
+	case source.AssumeRole != nil:
+		return f.assumeRoleSession(region, source.AssumeRole)
+	case source.Profile != "":
+		sess, err := session.NewSessionWithOptions(session.Options{
+			Profile:           source.Profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return sess, "profile:" + source.Profile, nil
+	default:
+		return f.session, "", nil
+	}
+}
+
+func (f *credentialSourceClientFactory) assumeRoleSession(region string, roleConfig *AssumeRoleConfig) (*session.Session, string, error) {
+	var creds *credentials.Credentials
+	if roleConfig.WebIdentityTokenFile != "" {
+		creds = stscreds.NewWebIdentityCredentials(f.session, roleConfig.RoleARN, roleConfig.SessionName, roleConfig.WebIdentityTokenFile)
+	} else {
+		creds = stscreds.NewCredentials(f.session, roleConfig.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = roleConfig.SessionName
+			if roleConfig.ExternalID != "" {
+				p.ExternalID = aws.String(roleConfig.ExternalID)
+			}
+		})
+	}
+
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(creds).WithRegion(region))
+	if err != nil {
+		return nil, "", err
+	}
+
+	principal := roleConfig.RoleARN
+	if identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{}); err == nil {
+		principal = aws.StringValue(identity.Arn)
+	} else {
+		logrus.WithError(err).WithField("roleARN", roleConfig.RoleARN).Debug("Could not confirm assumed role identity")
+	}
+	return sess, principal, nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package api
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecr-credential-helper/ecr-login/cache"
+	"github.com/sirupsen/logrus"
+)
+
+// refreshFraction is the point in a token's lifetime, expressed as a
+// fraction of the time between issuance and expiry, at which the
+// refreshing client proactively fetches a replacement.
+const refreshFraction = 0.6
+
+// jitterFraction bounds the random jitter added to (or subtracted from) the
+// computed refresh delay, so that many registries refreshing on the same
+// cadence don't all call ECR at once.
+const jitterFraction = 0.1
+
+const minBackoff = 1 * time.Second
+const maxBackoff = 5 * time.Minute
+
+// RefreshingClientConfig configures NewRefreshingClient.
+type RefreshingClientConfig struct {
+	ClientFactory   ClientFactory
+	CredentialCache cache.CredentialsCache
+	DefaultRegion   string
+}
+
+// RefreshingClient is a Client whose cached tokens are kept warm by
+// background workers; Close stops those workers.
+type RefreshingClient interface {
+	Client
+	Close()
+}
+
+// refreshingClient wraps a defaultClient and keeps one background goroutine
+// per registry it has seen, refreshing that registry's cached token well
+// before it expires so that callers never observe an expired token and
+// rarely wait on an ECR round trip.
+type refreshingClient struct {
+	Client
+	inner *defaultClient
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	watchersLock sync.Mutex
+	watchers     map[string]bool
+}
+
+// NewRefreshingClient returns a Client that proactively refreshes cached
+// tokens in the background instead of only refreshing on cache miss or
+// expiry. Call Close to stop the background workers.
+func NewRefreshingClient(ctx context.Context, config RefreshingClientConfig) RefreshingClient {
+	ctx, cancel := context.WithCancel(ctx)
+	inner := NewClient(config.ClientFactory, config.CredentialCache, config.DefaultRegion).(*defaultClient)
+	return &refreshingClient{
+		Client:   inner,
+		inner:    inner,
+		ctx:      ctx,
+		cancel:   cancel,
+		watchers: make(map[string]bool),
+	}
+}
+
+// GetCredentials behaves like defaultClient.GetCredentials, additionally
+// ensuring a background refresh watcher is running for the resolved registry.
+func (c *refreshingClient) GetCredentials(serverURL string) (*Auth, error) {
+	auth, err := c.inner.GetCredentials(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if registry, err := ExtractPublicRegistry(serverURL); err == nil {
+		c.watch(registry)
+	} else if registry, err := ExtractRegistry(serverURL); err == nil {
+		c.watch(registry)
+	}
+	return auth, nil
+}
+
+// GetCredentialsByRegistryID behaves like defaultClient.GetCredentialsByRegistryID,
+// additionally ensuring a background refresh watcher is running for the
+// resolved registry.
+func (c *refreshingClient) GetCredentialsByRegistryID(registryID string, region ...string) (*Auth, error) {
+	auth, err := c.inner.GetCredentialsByRegistryID(registryID, region...)
+	if err != nil {
+		return nil, err
+	}
+	if registryID == ecrPublicRegistryID {
+		c.watch(publicRegistry)
+	} else if registry, err := c.inner.resolveRegistry(registryID, region...); err == nil {
+		c.watch(registry)
+	}
+	return auth, nil
+}
+
+// Close stops all background refresh workers and waits for them to exit.
+func (c *refreshingClient) Close() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+func (c *refreshingClient) watch(registry *Registry) {
+	key := cacheKey(registry)
+
+	c.watchersLock.Lock()
+	defer c.watchersLock.Unlock()
+	if c.watchers[key] {
+		return
+	}
+	c.watchers[key] = true
+
+	c.wg.Add(1)
+	go c.refreshLoop(registry)
+}
+
+// stopWatching clears registry's watcher bookkeeping so that a later
+// GetCredentials/GetCredentialsByRegistryID call can restart a background
+// watcher for it, as documented on nextRefreshDelay.
+func (c *refreshingClient) stopWatching(registry *Registry) {
+	key := cacheKey(registry)
+
+	c.watchersLock.Lock()
+	defer c.watchersLock.Unlock()
+	delete(c.watchers, key)
+}
+
+func (c *refreshingClient) refreshLoop(registry *Registry) {
+	defer c.wg.Done()
+	defer c.stopWatching(registry)
+
+	backoff := minBackoff
+	for {
+		delay, ok := c.nextRefreshDelay(registry)
+		if !ok {
+			return
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if _, err := c.refresh(registry); err != nil {
+			logrus.
+				WithError(err).
+				WithField("registry", registry.ID).
+				WithField("region", registry.Region).
+				Warn("Background token refresh failed, will retry with backoff")
+
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(jitter(backoff)):
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+	}
+}
+
+// refresh fetches a new token for registry, routing to ECR Public when
+// appropriate, and caches it the same way a foreground lookup would.
+func (c *refreshingClient) refresh(registry *Registry) (*Auth, error) {
+	if registry.Public {
+		return c.inner.fetchPublicAuthorizationToken()
+	}
+	return c.inner.getAuthorizationToken(registry)
+}
+
+// nextRefreshDelay returns how long to wait before refreshing registry's
+// token, based on the cached entry's requested/expiry time. It returns
+// false if there is no cached entry to base a schedule on, in which case
+// the watcher should exit; GetCredentials/GetCredentialsByRegistryID will
+// restart it the next time the registry is used.
+func (c *refreshingClient) nextRefreshDelay(registry *Registry) (time.Duration, bool) {
+	entry := c.inner.credentialCache.Get(cacheKey(registry))
+	if entry == nil {
+		return 0, false
+	}
+
+	lifetime := entry.ExpiresAt.Sub(entry.RequestedAt)
+	refreshAt := entry.RequestedAt.Add(time.Duration(float64(lifetime) * refreshFraction))
+	delay := time.Until(refreshAt)
+	if delay < 0 {
+		delay = 0
+	}
+	return jitter(delay), true
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	spread := float64(d) * jitterFraction
+	offset := (rand.Float64()*2 - 1) * spread
+	return d + time.Duration(offset)
+}
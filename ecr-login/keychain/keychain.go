@@ -0,0 +1,76 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package keychain adapts the ECR credential helper to go-containerregistry's
+// authn.Keychain/authn.Authenticator interfaces, so that tools built on
+// go-containerregistry (e.g. Trivy, Flux, kaniko, ko) can authenticate
+// against ECR without reimplementing the wrapper around this helper.
+package keychain
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/awslabs/amazon-ecr-credential-helper/ecr-login/api"
+	"github.com/awslabs/amazon-ecr-credential-helper/ecr-login/cache"
+)
+
+type ecrKeychain struct {
+	client api.Client
+}
+
+// NewECRKeychain returns an authn.Keychain backed by client. The keychain is
+// safe for concurrent use across many image pulls.
+func NewECRKeychain(client api.Client) authn.Keychain {
+	return &ecrKeychain{client: client}
+}
+
+// NewECRKeychainWithClientFactory builds its own api.Client from
+// clientFactory and credentialCache, for callers that want to plug in a
+// custom api.ClientFactory (e.g. one that resolves per-registry AssumeRole
+// or profile credential sources) without constructing the api.Client
+// themselves.
+func NewECRKeychainWithClientFactory(clientFactory api.ClientFactory, credentialCache cache.CredentialsCache, defaultRegion string) authn.Keychain {
+	return NewECRKeychain(api.NewClient(clientFactory, credentialCache, defaultRegion))
+}
+
+// Resolve implements authn.Keychain. Resources that aren't an ECR registry
+// resolve to authn.Anonymous rather than an error, so that a keychain chain
+// including this one can fall through to other sources.
+func (k *ecrKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	registry, err := api.ExtractPublicRegistry(resource.String())
+	if err != nil {
+		registry, err = api.ExtractRegistry(resource.String())
+	}
+	if err != nil {
+		return authn.Anonymous, nil
+	}
+
+	auth, err := k.client.GetCredentialsByRegistryID(registry.ID, registry.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecrAuthenticator{auth: auth}, nil
+}
+
+type ecrAuthenticator struct {
+	auth *api.Auth
+}
+
+// Authorization implements authn.Authenticator.
+func (a *ecrAuthenticator) Authorization() (*authn.AuthConfig, error) {
+	return &authn.AuthConfig{
+		Username: a.auth.Username,
+		Password: a.auth.Password,
+	}, nil
+}